@@ -0,0 +1,160 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"configcenter/src/storage/stream/types"
+)
+
+// CompoundCursorElement is a single resource's resume position within a CompoundCursor.
+type CompoundCursorElement struct {
+	Type        CursorType
+	ClusterTime types.TimeStamp
+	Oid         string
+}
+
+// CompoundCursor merges the resume position of several CursorTypes into a single token, so
+// a caller watching more than one resource together (e.g. hosts, host_relations and modules
+// under one biz) only has to keep track of one resumable cursor instead of reconciling one
+// per resource. It shares its framing and signing code with Cursor, so a v2 CompoundCursor is
+// just as tamper-resistant as a v2 Cursor.
+type CompoundCursor struct {
+	Elements []CompoundCursorElement
+}
+
+func (c CompoundCursor) Encode() (string, error) {
+	if len(c.Elements) == 0 {
+		return "", errors.New("compound cursor must have at least one element")
+	}
+
+	fields := make([]string, 0, 1+4*len(c.Elements))
+	fields = append(fields, strconv.Itoa(len(c.Elements)))
+	for _, ele := range c.Elements {
+		if ele.Type == "" || ele.ClusterTime.Sec == 0 || ele.Oid == "" {
+			return "", errors.New("invalid compound cursor element")
+		}
+
+		if ele.Type.ToInt() < 0 {
+			return "", errors.New("unsupported cursor type")
+		}
+
+		fields = append(fields,
+			strconv.Itoa(ele.Type.ToInt()),
+			ele.Oid,
+			strconv.FormatUint(uint64(ele.ClusterTime.Sec), 10),
+			strconv.FormatUint(uint64(ele.ClusterTime.Nano), 10),
+		)
+	}
+
+	if cursorSecurity == nil || len(cursorSecurity.Keys) == 0 {
+		return encodeCursorFields(append([]string{cursorVersionV1}, fields...)...), nil
+	}
+
+	key, exist := cursorSecurity.Keys[cursorSecurity.ActiveKeyID]
+	if !exist {
+		return "", fmt.Errorf("signing key %d is not configured", cursorSecurity.ActiveKeyID)
+	}
+
+	keyID := strconv.Itoa(int(cursorSecurity.ActiveKeyID))
+	signedFields := append([]string{cursorVersionV2, keyID}, fields...)
+	tag := signCursorFields(key, signedFields...)
+	return encodeCursorFields(append(signedFields, tag)...), nil
+}
+
+func (c *CompoundCursor) Decode(cur string) error {
+	elements, err := decodeCursorFields(cur)
+	if err != nil {
+		return err
+	}
+
+	if len(elements) == 0 {
+		return errors.New("invalid cursor string")
+	}
+
+	switch elements[0] {
+	case cursorVersionV1:
+		if cursorSecurity != nil && !cursorSecurity.AllowV1 {
+			return errors.New("v1 cursor is no longer accepted, please resume watching with a v2 cursor")
+		}
+		return c.decodeFields(elements[1:])
+
+	case cursorVersionV2:
+		if cursorSecurity == nil {
+			return errors.New("v2 cursor received, but no signing key is configured")
+		}
+
+		if len(elements) < 3 {
+			return errors.New("invalid v2 compound cursor string")
+		}
+
+		keyIDNum, err := strconv.Atoi(elements[1])
+		if err != nil || keyIDNum < 0 || keyIDNum > 255 {
+			return fmt.Errorf("got invalid cursor key id: %s", elements[1])
+		}
+
+		key, exist := cursorSecurity.Keys[byte(keyIDNum)]
+		if !exist {
+			return fmt.Errorf("cursor signed with unknown key id: %d", keyIDNum)
+		}
+
+		body, tag := elements[:len(elements)-1], elements[len(elements)-1]
+		wantTag := signCursorFields(key, body...)
+		if !hmac.Equal([]byte(wantTag), []byte(tag)) {
+			return errors.New("cursor signature verification failed, cursor has been tampered with")
+		}
+
+		return c.decodeFields(body[2:])
+
+	default:
+		return fmt.Errorf("decode cursor, but got invalid cursor version: %s", elements[0])
+	}
+}
+
+// decodeFields parses the element count followed by count*(type, oid, sec, nano) groups
+// shared by every compound cursor version, reusing Cursor's single-element field parsing.
+func (c *CompoundCursor) decodeFields(fields []string) error {
+	if len(fields) == 0 {
+		return errors.New("invalid compound cursor string")
+	}
+
+	count, err := strconv.Atoi(fields[0])
+	if err != nil || count <= 0 {
+		return fmt.Errorf("got invalid compound cursor element count: %s", fields[0])
+	}
+
+	if len(fields) != 1+count*4 {
+		return errors.New("invalid compound cursor string")
+	}
+
+	elements := make([]CompoundCursorElement, 0, count)
+	for i := 0; i < count; i++ {
+		base := 1 + i*4
+		var single Cursor
+		if err := single.decodeFields(fields[base], fields[base+1], fields[base+2], fields[base+3]); err != nil {
+			return err
+		}
+		elements = append(elements, CompoundCursorElement{
+			Type:        single.Type,
+			ClusterTime: single.ClusterTime,
+			Oid:         single.Oid,
+		})
+	}
+
+	c.Elements = elements
+	return nil
+}
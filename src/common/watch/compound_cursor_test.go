@@ -0,0 +1,141 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import (
+	"testing"
+
+	"configcenter/src/storage/stream/types"
+)
+
+func testCompoundCursor() CompoundCursor {
+	return CompoundCursor{
+		Elements: []CompoundCursorElement{
+			{Type: Host, ClusterTime: types.TimeStamp{Sec: 100, Nano: 1}, Oid: "5ea6d3f394c1f5d986e9bd86"},
+			{Type: Biz, ClusterTime: types.TimeStamp{Sec: 101, Nano: 2}, Oid: "5ea6d3f394c1f5d986e9bd87"},
+			{Type: Module, ClusterTime: types.TimeStamp{Sec: 102, Nano: 3}, Oid: "5ea6d3f394c1f5d986e9bd88"},
+		},
+	}
+}
+
+func assertCompoundCursorElementsEqual(t *testing.T, got, want []CompoundCursorElement) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("element count mismatch, got %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("element %d mismatch, got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCompoundCursorEncodeDecodeRoundTripV1(t *testing.T) {
+	resetCursorSecurity()
+	defer resetCursorSecurity()
+
+	c := testCompoundCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v1 compound cursor failed: %v", err)
+	}
+
+	decoded := CompoundCursor{}
+	if err := decoded.Decode(encoded); err != nil {
+		t.Fatalf("decode v1 compound cursor failed: %v", err)
+	}
+
+	assertCompoundCursorElementsEqual(t, decoded.Elements, c.Elements)
+}
+
+func TestCompoundCursorEncodeDecodeRoundTripV2(t *testing.T) {
+	InitCursorSecurity(CursorSecurity{
+		ActiveKeyID: 1,
+		Keys:        map[byte][]byte{1: []byte("test-secret-key-one")},
+	})
+	defer resetCursorSecurity()
+
+	c := testCompoundCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v2 compound cursor failed: %v", err)
+	}
+
+	decoded := CompoundCursor{}
+	if err := decoded.Decode(encoded); err != nil {
+		t.Fatalf("decode v2 compound cursor failed: %v", err)
+	}
+
+	assertCompoundCursorElementsEqual(t, decoded.Elements, c.Elements)
+}
+
+// TestCompoundCursorDecodeRejectsTamperedElement proves that swapping one element's oid
+// without recomputing the tag - as a client forging a cursor would have to do - is rejected.
+func TestCompoundCursorDecodeRejectsTamperedElement(t *testing.T) {
+	InitCursorSecurity(CursorSecurity{
+		ActiveKeyID: 1,
+		Keys:        map[byte][]byte{1: []byte("test-secret-key-one")},
+	})
+	defer resetCursorSecurity()
+
+	c := testCompoundCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v2 compound cursor failed: %v", err)
+	}
+
+	elements, err := decodeCursorFields(encoded)
+	if err != nil {
+		t.Fatalf("split cursor fields failed: %v", err)
+	}
+
+	// elements are: version, keyId, count, then count*(type, oid, sec, nano), then tag.
+	// index 4 is the first element's oid field.
+	elements[4] = "5ea6d3f394c1f5d986e9bd99"
+	tampered := encodeCursorFields(elements...)
+
+	decoded := CompoundCursor{}
+	if err := decoded.Decode(tampered); err == nil {
+		t.Fatalf("expected a tampered compound cursor element to be rejected")
+	}
+}
+
+// TestCompoundCursorDecodeRejectsWrongElementCount proves that a count field that doesn't
+// match the number of element groups actually present is rejected rather than parsed as
+// whatever partial/garbage data happens to be there.
+func TestCompoundCursorDecodeRejectsWrongElementCount(t *testing.T) {
+	resetCursorSecurity()
+	defer resetCursorSecurity()
+
+	c := testCompoundCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v1 compound cursor failed: %v", err)
+	}
+
+	elements, err := decodeCursorFields(encoded)
+	if err != nil {
+		t.Fatalf("split cursor fields failed: %v", err)
+	}
+
+	// elements are: version, count, then count*(type, oid, sec, nano). Claim there are more
+	// elements than are actually present in the string.
+	elements[1] = "99"
+	malformed := encodeCursorFields(elements...)
+
+	decoded := CompoundCursor{}
+	if err := decoded.Decode(malformed); err == nil {
+		t.Fatalf("expected a compound cursor with a mismatched element count to be rejected")
+	}
+}
@@ -14,13 +14,16 @@ package watch
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 
-	"configcenter/src/common"
 	"configcenter/src/common/blog"
 	"configcenter/src/storage/stream/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -50,117 +53,77 @@ func init() {
 type CursorType string
 
 const (
-	NoEvent            CursorType = "no_event"
-	UnknownType        CursorType = "unknown"
-	Host               CursorType = "host"
-	ModuleHostRelation CursorType = "host_relation"
-	Biz                CursorType = "biz"
-	Set                CursorType = "set"
-	Module             CursorType = "module"
-	ObjectBase         CursorType = "object"
+	NoEvent     CursorType = "no_event"
+	UnknownType CursorType = "unknown"
 )
 
+// noEventIntCode is the reserved intCode of the NoEvent pseudo cursor type. It's handled
+// directly here rather than through the registry since it doesn't back a real collection.
+const noEventIntCode = 1
+
+// eventTypeSuffixes are the mutation kinds appended to a registration's EventTypePrefix by
+// the event chain. Some resources (e.g. host_relation) report a single event type with no
+// suffix at all, so an exact prefix match is tried first.
+var eventTypeSuffixes = []string{"create", "update", "delete"}
+
 func (ct CursorType) ToInt() int {
-	switch ct {
-	case NoEvent:
-		return 1
-	case Host:
-		return 2
-	case ModuleHostRelation:
-		return 3
-	case Biz:
-		return 4
-	case Set:
-		return 5
-	case Module:
-		return 6
-	case ObjectBase:
-		return 7
-	default:
-		return -1
+	if ct == NoEvent {
+		return noEventIntCode
+	}
+
+	if reg, exist := registryByType[ct]; exist {
+		return reg.IntCode
 	}
+
+	return -1
 }
 
 func (ct *CursorType) ParseInt(typ int) {
-	switch typ {
-	case 1:
+	if typ == noEventIntCode {
 		*ct = NoEvent
-	case 2:
-		*ct = Host
-	case 3:
-		*ct = ModuleHostRelation
-	case 4:
-		*ct = Biz
-	case 5:
-		*ct = Set
-	case 6:
-		*ct = Module
-	case 7:
-		*ct = ObjectBase
-	default:
-		*ct = UnknownType
+		return
+	}
+
+	if resolved, exist := registryByIntCode[typ]; exist {
+		*ct = resolved
+		return
 	}
+
+	*ct = UnknownType
 }
 
-// ListCursorTypes returns all support CursorTypes.
+// ListCursorTypes returns all the registered, watchable CursorTypes, ordered by intCode so
+// the result stays stable regardless of registration (init) order.
 func ListCursorTypes() []CursorType {
-	return []CursorType{Host, ModuleHostRelation, Biz, Set, Module, ObjectBase}
+	intCodes := make([]int, 0, len(registryByIntCode))
+	for code := range registryByIntCode {
+		intCodes = append(intCodes, code)
+	}
+	sort.Ints(intCodes)
+
+	types := make([]CursorType, 0, len(intCodes))
+	for _, code := range intCodes {
+		types = append(types, registryByIntCode[code])
+	}
+	return types
 }
 
-// ParseCursorTypeFromEventType returns target cursor type type base on event type.
+// ParseCursorTypeFromEventType returns the target cursor type based on the raw event type
+// reported by the event chain.
 func ParseCursorTypeFromEventType(eventType string) CursorType {
-	switch eventType {
-	case "hostcreate":
-		return Host
-
-	case "hostupdate":
-		return Host
-
-	case "hostdelete":
-		return Host
-
-	case "host_relation":
-		return ModuleHostRelation
-
-	case "bizcreate":
-		return Biz
-
-	case "bizupdate":
-		return Biz
-
-	case "bizdelete":
-		return Biz
-
-	case "setcreate":
-		return Set
-
-	case "setupdate":
-		return Set
-
-	case "setdelete":
-		return Set
-
-	case "modulecreate":
-		return Module
-
-	case "moduleupdate":
-		return Module
-
-	case "moduledelete":
-		return Module
-
-	case "objectcreate":
-		return ObjectBase
-
-	case "objectupdate":
-		return ObjectBase
-
-	case "objectdelete":
-		return ObjectBase
+	for _, reg := range registryByType {
+		if eventType == reg.EventTypePrefix {
+			return reg.Type
+		}
 
-	default:
-		return UnknownType
+		for _, suffix := range eventTypeSuffixes {
+			if eventType == reg.EventTypePrefix+suffix {
+				return reg.Type
+			}
+		}
 	}
+
+	return UnknownType
 }
 
 // Cursor is a self-defined token which is corresponding to the mongodb's resume token.
@@ -172,7 +135,42 @@ type Cursor struct {
 	Oid string
 }
 
-const cursorVersion = "1"
+const (
+	// cursorVersionV1 is the legacy, unsigned cursor format. it's grandfathered in so that
+	// watchers holding a cursor handed out before the v2 rollout keep working during the
+	// migration window, but it's trivially forge-able and should be phased out.
+	cursorVersionV1 = "1"
+	// cursorVersionV2 is the HMAC-SHA256 signed cursor format. Encode emits v2 as soon as a
+	// signing key is configured via InitCursorSecurity.
+	cursorVersionV2 = "2"
+)
+
+// CursorSecurity holds the server-side secret material used to sign and verify v2 cursors.
+// it's populated once at process start-up from configuration and must never be derived
+// from anything the caller can influence, otherwise a client could forge a cursor that
+// points the watcher at an arbitrary point in the oplog.
+type CursorSecurity struct {
+	// ActiveKeyID is the id of the key that newly encoded cursors are signed with.
+	ActiveKeyID byte
+	// Keys maps a key-id to the HMAC-SHA256 secret used to sign/verify cursors carrying
+	// that id. Keeping a retired key around after rotating ActiveKeyID lets cursors that
+	// are already in the hands of live watchers keep verifying until they naturally expire.
+	Keys map[byte][]byte
+	// AllowV1 keeps the legacy, unsigned v1 cursor format acceptable to Decode. it should
+	// only be flipped off once every client is known to have rolled over to v2, since doing
+	// so immediately invalidates any v1 cursor still outstanding.
+	AllowV1 bool
+}
+
+// cursorSecurity is nil until InitCursorSecurity is called, in which case Encode keeps
+// emitting the legacy v1 format and Decode accepts only v1.
+var cursorSecurity *CursorSecurity
+
+// InitCursorSecurity configures the secret material used to sign and verify v2 cursors.
+// it must be called once during start-up, before the process serves any watch request.
+func InitCursorSecurity(cfg CursorSecurity) {
+	cursorSecurity = &cfg
+}
 
 func (c Cursor) Encode() (string, error) {
 	if c.Type == "" {
@@ -187,117 +185,186 @@ func (c Cursor) Encode() (string, error) {
 		return "", errors.New("invalid oid")
 	}
 
-	sec := strconv.FormatUint(uint64(c.ClusterTime.Sec), 10)
-	nano := strconv.FormatUint(uint64(c.ClusterTime.Nano), 10)
-	pool := bytes.Buffer{}
-	// version field.
-	pool.WriteString(cursorVersion)
-	pool.WriteByte('\r')
-
-	// type filed.
 	if c.Type.ToInt() < 0 {
 		return "", errors.New("unsupported cursor type")
 	}
 
-	pool.WriteString(strconv.Itoa(c.Type.ToInt()))
-	pool.WriteByte('\r')
-
-	// oid field.
-	pool.WriteString(c.Oid)
-	pool.WriteByte('\r')
+	sec := strconv.FormatUint(uint64(c.ClusterTime.Sec), 10)
+	nano := strconv.FormatUint(uint64(c.ClusterTime.Nano), 10)
+	typ := strconv.Itoa(c.Type.ToInt())
 
-	// cluster time sec field.
-	pool.WriteString(sec)
-	pool.WriteByte('\r')
+	if cursorSecurity == nil || len(cursorSecurity.Keys) == 0 {
+		return encodeCursorFields(cursorVersionV1, typ, c.Oid, sec, nano), nil
+	}
 
-	// cluster time nano field
-	pool.WriteString(nano)
+	key, exist := cursorSecurity.Keys[cursorSecurity.ActiveKeyID]
+	if !exist {
+		return "", fmt.Errorf("signing key %d is not configured", cursorSecurity.ActiveKeyID)
+	}
 
-	return base64.StdEncoding.EncodeToString(pool.Bytes()), nil
+	keyID := strconv.Itoa(int(cursorSecurity.ActiveKeyID))
+	signed := signCursorFields(key, cursorVersionV2, keyID, typ, c.Oid, sec, nano)
+	return encodeCursorFields(cursorVersionV2, keyID, typ, c.Oid, sec, nano, signed), nil
 }
 
 func (c *Cursor) Decode(cur string) error {
-	byt, err := base64.StdEncoding.DecodeString(cur)
+	elements, err := decodeCursorFields(cur)
 	if err != nil {
-		return fmt.Errorf("decode cursor, but base64 decode failed, err: %v", err)
+		return err
 	}
 
-	elements := make([]string, 0)
-	pool := bytes.NewBuffer(byt)
+	if len(elements) == 0 {
+		return errors.New("invalid cursor string")
+	}
 
-	ele := make([]byte, 0)
-	for {
-		b, err := pool.ReadByte()
-		if err != nil {
-			if err != io.EOF {
-				return err
-			}
-			// to the end
-			elements = append(elements, string(ele))
-			break
-		}
-		if b == '\r' {
-			elements = append(elements, string(ele))
-			ele = ele[:0]
-		} else {
-			ele = append(ele, b)
+	switch elements[0] {
+	case cursorVersionV1:
+		if cursorSecurity != nil && !cursorSecurity.AllowV1 {
+			return errors.New("v1 cursor is no longer accepted, please resume watching with a v2 cursor")
 		}
+		return c.decodeV1(elements)
+	case cursorVersionV2:
+		return c.decodeV2(elements)
+	default:
+		return fmt.Errorf("decode cursor, but got invalid cursor version: %s", elements[0])
 	}
+}
 
+// decodeV1 parses the legacy, unsigned cursor frame: version, type, oid, sec, nano.
+func (c *Cursor) decodeV1(elements []string) error {
 	if len(elements) != 5 {
-		return errors.New("invalid cursor string")
+		return errors.New("invalid v1 cursor string")
 	}
 
-	if elements[0] != cursorVersion {
-		return fmt.Errorf("decode cursor, but got invalid cursor version: %s", elements[0])
+	return c.decodeFields(elements[1], elements[2], elements[3], elements[4])
+}
+
+// decodeV2 parses the signed cursor frame: version, keyId, type, oid, sec, nano, tag. it
+// rejects the cursor unless the tag verifies against the key identified by keyId, so a
+// tampered field or an unknown key-id is caught here. Unsigned v1 cursors never reach this
+// function; Decode rejects them itself once AllowV1 is off.
+func (c *Cursor) decodeV2(elements []string) error {
+	if len(elements) != 7 {
+		return errors.New("invalid v2 cursor string")
+	}
+
+	if cursorSecurity == nil {
+		return errors.New("v2 cursor received, but no signing key is configured")
 	}
 
-	typ, err := strconv.Atoi(elements[1])
+	keyIDNum, err := strconv.Atoi(elements[1])
+	if err != nil || keyIDNum < 0 || keyIDNum > 255 {
+		return fmt.Errorf("got invalid cursor key id: %s", elements[1])
+	}
+
+	key, exist := cursorSecurity.Keys[byte(keyIDNum)]
+	if !exist {
+		return fmt.Errorf("cursor signed with unknown key id: %d", keyIDNum)
+	}
+
+	wantTag := signCursorFields(key, elements[0], elements[1], elements[2], elements[3], elements[4], elements[5])
+	if !hmac.Equal([]byte(wantTag), []byte(elements[6])) {
+		return errors.New("cursor signature verification failed, cursor has been tampered with")
+	}
+
+	return c.decodeFields(elements[2], elements[3], elements[4], elements[5])
+}
+
+// decodeFields parses the type/oid/sec/nano fields shared by every cursor version.
+func (c *Cursor) decodeFields(typField, oidField, secField, nanoField string) error {
+	typ, err := strconv.Atoi(typField)
 	if err != nil {
-		return fmt.Errorf("got invalid type: %s", elements[1])
+		return fmt.Errorf("got invalid type: %s", typField)
 	}
 	cursorType := CursorType("")
 	cursorType.ParseInt(typ)
 	c.Type = cursorType
 
-	_, err = primitive.ObjectIDFromHex(elements[2])
-	if err != nil {
-		return fmt.Errorf("got invalid oid: %s, err: %v", elements[2], err)
+	if _, err := primitive.ObjectIDFromHex(oidField); err != nil {
+		return fmt.Errorf("got invalid oid: %s, err: %v", oidField, err)
 	}
-	c.Oid = elements[2]
+	c.Oid = oidField
 
-	sec, err := strconv.ParseUint(elements[3], 10, 64)
+	sec, err := strconv.ParseUint(secField, 10, 64)
 	if err != nil {
-		return fmt.Errorf("got invalid sec field %s, err: %v", elements[3], err)
+		return fmt.Errorf("got invalid sec field %s, err: %v", secField, err)
 	}
 	c.ClusterTime.Sec = uint32(sec)
 
-	nano, err := strconv.ParseUint(elements[4], 10, 64)
+	nano, err := strconv.ParseUint(nanoField, 10, 64)
 	if err != nil {
-		return fmt.Errorf("got invalid nano field %s, err: %v", elements[4], err)
+		return fmt.Errorf("got invalid nano field %s, err: %v", nanoField, err)
 	}
 	c.ClusterTime.Nano = uint32(nano)
 
 	return nil
 }
 
+// encodeCursorFields joins the cursor's fields with the '\r' delimiter used by every
+// cursor version and base64 encodes the result. it's shared by the single and compound
+// cursor formats so they stay framed identically.
+func encodeCursorFields(fields ...string) string {
+	pool := bytes.Buffer{}
+	for i, field := range fields {
+		if i > 0 {
+			pool.WriteByte('\r')
+		}
+		pool.WriteString(field)
+	}
+	return base64.StdEncoding.EncodeToString(pool.Bytes())
+}
+
+// decodeCursorFields reverses encodeCursorFields, splitting the base64 decoded payload
+// back into its '\r' delimited fields.
+func decodeCursorFields(cur string) ([]string, error) {
+	byt, err := base64.StdEncoding.DecodeString(cur)
+	if err != nil {
+		return nil, fmt.Errorf("decode cursor, but base64 decode failed, err: %v", err)
+	}
+
+	elements := make([]string, 0)
+	pool := bytes.NewBuffer(byt)
+
+	ele := make([]byte, 0)
+	for {
+		b, err := pool.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			// to the end
+			elements = append(elements, string(ele))
+			break
+		}
+		if b == '\r' {
+			elements = append(elements, string(ele))
+			ele = ele[:0]
+		} else {
+			ele = append(ele, b)
+		}
+	}
+
+	return elements, nil
+}
+
+// signCursorFields computes the hex encoded HMAC-SHA256 tag over the given fields, framed
+// the same way encodeCursorFields would, so the signature covers exactly the bytes that
+// get transmitted.
+func signCursorFields(key []byte, fields ...string) string {
+	mac := hmac.New(sha256.New, key)
+	for i, field := range fields {
+		if i > 0 {
+			mac.Write([]byte{'\r'})
+		}
+		mac.Write([]byte(field))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func GetEventCursor(coll string, e *types.Event) (string, error) {
-	curType := UnknownType
-	switch coll {
-	case common.BKTableNameBaseHost:
-		curType = Host
-	case common.BKTableNameModuleHostConfig:
-		curType = ModuleHostRelation
-	case common.BKTableNameBaseApp:
-		curType = Biz
-	case common.BKTableNameBaseSet:
-		curType = Set
-	case common.BKTableNameBaseModule:
-		curType = Module
-	case common.BKTableNameBaseInst:
-		curType = ObjectBase
-	default:
-		blog.Errorf("unsupported cursor type collection: %s, oid: %s", e.Oid)
+	curType, exist := GetCursorTypeByCollection(coll)
+	if !exist {
+		blog.Errorf("unsupported cursor type collection: %s, oid: %s", coll, e.Oid)
 		return "", fmt.Errorf("unsupported cursor type collection: %s", coll)
 	}
 
@@ -0,0 +1,159 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import (
+	"testing"
+
+	"configcenter/src/storage/stream/types"
+)
+
+// resetCursorSecurity restores the package to its unconfigured, v1-only state.
+func resetCursorSecurity() {
+	cursorSecurity = nil
+}
+
+func testCursor() Cursor {
+	return Cursor{
+		Type:        Host,
+		ClusterTime: types.TimeStamp{Sec: 100, Nano: 1},
+		Oid:         "5ea6d3f394c1f5d986e9bd86",
+	}
+}
+
+func TestCursorEncodeDecodeRoundTripV1(t *testing.T) {
+	resetCursorSecurity()
+	defer resetCursorSecurity()
+
+	c := testCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v1 cursor failed: %v", err)
+	}
+
+	decoded := Cursor{}
+	if err := decoded.Decode(encoded); err != nil {
+		t.Fatalf("decode v1 cursor failed: %v", err)
+	}
+
+	if decoded != c {
+		t.Fatalf("v1 round trip mismatch, got %+v, want %+v", decoded, c)
+	}
+}
+
+func TestCursorEncodeDecodeRoundTripV2(t *testing.T) {
+	InitCursorSecurity(CursorSecurity{
+		ActiveKeyID: 1,
+		Keys:        map[byte][]byte{1: []byte("test-secret-key-one")},
+		AllowV1:     true,
+	})
+	defer resetCursorSecurity()
+
+	c := testCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v2 cursor failed: %v", err)
+	}
+
+	decoded := Cursor{}
+	if err := decoded.Decode(encoded); err != nil {
+		t.Fatalf("decode v2 cursor failed: %v", err)
+	}
+
+	if decoded != c {
+		t.Fatalf("v2 round trip mismatch, got %+v, want %+v", decoded, c)
+	}
+}
+
+// TestCursorDecodeRejectsTamperedField proves that flipping a field covered by the HMAC tag
+// - without recomputing the tag, as a client forging a cursor would have to do - is rejected.
+func TestCursorDecodeRejectsTamperedField(t *testing.T) {
+	InitCursorSecurity(CursorSecurity{
+		ActiveKeyID: 1,
+		Keys:        map[byte][]byte{1: []byte("test-secret-key-one")},
+	})
+	defer resetCursorSecurity()
+
+	c := testCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v2 cursor failed: %v", err)
+	}
+
+	elements, err := decodeCursorFields(encoded)
+	if err != nil {
+		t.Fatalf("split cursor fields failed: %v", err)
+	}
+
+	// elements are: version, keyId, type, oid, sec, nano, tag. Swap in a different, still
+	// validly formatted oid without touching the tag, as a client would have to.
+	elements[3] = "5ea6d3f394c1f5d986e9bd87"
+	tampered := encodeCursorFields(elements...)
+
+	decoded := Cursor{}
+	if err := decoded.Decode(tampered); err == nil {
+		t.Fatalf("expected a tampered cursor field to be rejected")
+	}
+}
+
+// TestCursorDecodeRejectsWrongKeyID proves that a cursor signed with a key that's been
+// retired (dropped from CursorSecurity.Keys during rotation) is rejected rather than, say,
+// falling back to accepting it unsigned.
+func TestCursorDecodeRejectsWrongKeyID(t *testing.T) {
+	InitCursorSecurity(CursorSecurity{
+		ActiveKeyID: 1,
+		Keys:        map[byte][]byte{1: []byte("test-secret-key-one")},
+	})
+
+	c := testCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v2 cursor failed: %v", err)
+	}
+
+	// rotate: key 1 is retired and no longer known to the verifier.
+	InitCursorSecurity(CursorSecurity{
+		ActiveKeyID: 2,
+		Keys:        map[byte][]byte{2: []byte("test-secret-key-two")},
+	})
+	defer resetCursorSecurity()
+
+	decoded := Cursor{}
+	if err := decoded.Decode(encoded); err == nil {
+		t.Fatalf("expected a cursor signed with an unknown key id to be rejected")
+	}
+}
+
+// TestCursorDecodeRejectsV1Downgrade proves that once AllowV1 is turned off, a client can't
+// get a resumable cursor accepted just by handing back the old unsigned v1 format.
+func TestCursorDecodeRejectsV1Downgrade(t *testing.T) {
+	resetCursorSecurity()
+
+	c := testCursor()
+	encoded, err := c.Encode()
+	if err != nil {
+		t.Fatalf("encode v1 cursor failed: %v", err)
+	}
+
+	InitCursorSecurity(CursorSecurity{
+		ActiveKeyID: 1,
+		Keys:        map[byte][]byte{1: []byte("test-secret-key-one")},
+		AllowV1:     false,
+	})
+	defer resetCursorSecurity()
+
+	decoded := Cursor{}
+	if err := decoded.Decode(encoded); err == nil {
+		t.Fatalf("expected a v1 cursor to be rejected once AllowV1 is false")
+	}
+}
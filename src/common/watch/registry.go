@@ -0,0 +1,112 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import "fmt"
+
+// Reserved intCode ranges. NoEvent (1) and the resources registered from this package's
+// own files (2-7, see host.go, host_relation.go, biz.go, set.go, module.go, object_base.go)
+// sit below ReservedCoreIntCodeMax. A new CMDB object that ships with this package should
+// register somewhere in [2, ReservedCoreIntCodeMax]; a resource added by an external plugin
+// (process, service_instance, cloud_area, common instance associations, ...) should start at
+// ReservedPluginIntCodeMin so the two never collide as both grow independently.
+const (
+	ReservedCoreIntCodeMax   = 49
+	ReservedPluginIntCodeMin = 50
+)
+
+// Registration describes how a watchable resource maps between its CursorType, the stable
+// integer code persisted inside a cursor, the mongodb collection backing it and the event
+// type prefix the event chain tags its raw db events with.
+type Registration struct {
+	Type            CursorType
+	IntCode         int
+	Collection      string
+	EventTypePrefix string
+}
+
+var (
+	registryByType       = make(map[CursorType]Registration)
+	registryByIntCode    = make(map[int]CursorType)
+	registryByCollection = make(map[string]CursorType)
+)
+
+// RegisterCursorType registers a watchable resource's cursor type. It's meant to be called
+// from an init() in the resource's own file, so that adding a new watchable collection only
+// requires dropping in a new file instead of editing this package's switch statements. It
+// panics on a colliding name, intCode or EventTypePrefix since any of those would silently
+// produce an ambiguous cursor or misrouted event, and panicking at init() time surfaces that
+// immediately instead of at runtime, where the winner would depend on map iteration order.
+func RegisterCursorType(reg Registration) {
+	if reg.Type == "" || reg.Type == NoEvent || reg.Type == UnknownType {
+		panic(fmt.Sprintf("watch: invalid cursor type registration: %+v", reg))
+	}
+
+	// intCode 1 is reserved for the NoEvent pseudo type, which is special-cased directly in
+	// CursorType.ToInt/ParseInt rather than going through this registry. A resource
+	// registering it would still resolve through ToInt, but Decode/ParseInt would keep
+	// resolving it straight to NoEvent, silently mistaking a real event for the "nothing
+	// happened" sentinel instead of even falling back to UnknownType.
+	if reg.IntCode == noEventIntCode {
+		panic(fmt.Sprintf("watch: cursor int code %d is reserved for NoEvent: %+v", noEventIntCode, reg))
+	}
+
+	if existing, exist := registryByType[reg.Type]; exist {
+		panic(fmt.Sprintf("watch: cursor type %s is already registered: %+v", reg.Type, existing))
+	}
+
+	if existing, exist := registryByIntCode[reg.IntCode]; exist {
+		panic(fmt.Sprintf("watch: cursor int code %d is already registered to %s", reg.IntCode, existing))
+	}
+
+	for _, existing := range registryByType {
+		if eventTypePrefixesCollide(existing.EventTypePrefix, reg.EventTypePrefix) {
+			panic(fmt.Sprintf("watch: event type prefix %q for %s collides with %q already registered for %s",
+				reg.EventTypePrefix, reg.Type, existing.EventTypePrefix, existing.Type))
+		}
+	}
+
+	registryByType[reg.Type] = reg
+	registryByIntCode[reg.IntCode] = reg.Type
+	if reg.Collection != "" {
+		registryByCollection[reg.Collection] = reg.Type
+	}
+}
+
+// eventTypePrefixesCollide reports whether two EventTypePrefixes would make
+// ParseCursorTypeFromEventType ambiguous: either they're identical, or one of them with a
+// mutation suffix appended equals the other - e.g. "host" and "hostcreate" collide because
+// "host"+"create" equals "hostcreate".
+func eventTypePrefixesCollide(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	if a == b {
+		return true
+	}
+
+	for _, suffix := range eventTypeSuffixes {
+		if a+suffix == b || b+suffix == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetCursorTypeByCollection returns the CursorType registered for a mongodb collection.
+func GetCursorTypeByCollection(collection string) (CursorType, bool) {
+	typ, exist := registryByCollection[collection]
+	return typ, exist
+}
@@ -0,0 +1,67 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import "testing"
+
+func expectPanic(t *testing.T, reason string) {
+	if r := recover(); r == nil {
+		t.Fatalf("expected RegisterCursorType to panic on %s", reason)
+	}
+}
+
+func TestRegisterCursorTypeCollidingNamePanics(t *testing.T) {
+	defer expectPanic(t, "a colliding cursor type name")
+
+	RegisterCursorType(Registration{
+		Type:            Host,
+		IntCode:         998,
+		Collection:      "some_collection",
+		EventTypePrefix: "something_else",
+	})
+}
+
+func TestRegisterCursorTypeCollidingIntCodePanics(t *testing.T) {
+	defer expectPanic(t, "a colliding int code")
+
+	RegisterCursorType(Registration{
+		Type:            CursorType("some_new_type"),
+		IntCode:         Host.ToInt(),
+		Collection:      "some_collection",
+		EventTypePrefix: "something_else",
+	})
+}
+
+func TestRegisterCursorTypeReservedNoEventIntCodePanics(t *testing.T) {
+	defer expectPanic(t, "the intCode reserved for NoEvent")
+
+	RegisterCursorType(Registration{
+		Type:            CursorType("evil"),
+		IntCode:         noEventIntCode,
+		Collection:      "some_collection",
+		EventTypePrefix: "something_else",
+	})
+}
+
+func TestRegisterCursorTypeCollidingEventTypePrefixPanics(t *testing.T) {
+	defer expectPanic(t, "a colliding event type prefix")
+
+	// Host is registered with EventTypePrefix "host", so "hostcreate" ("host"+"create")
+	// would silently shadow Host's own create events if it were allowed to register.
+	RegisterCursorType(Registration{
+		Type:            CursorType("some_other_new_type"),
+		IntCode:         998,
+		Collection:      "some_other_collection",
+		EventTypePrefix: "hostcreate",
+	})
+}
@@ -0,0 +1,195 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"configcenter/src/common/blog"
+	"configcenter/src/storage/stream/types"
+)
+
+// CollectionEvent pairs a raw db event with the collection it came from, since a batch
+// folded into a CompoundCursor can span more than one watched collection at once.
+type CollectionEvent struct {
+	Collection string
+	Event      *types.Event
+}
+
+// GetEventCursorMulti is GetEventCursor's compound sibling: it folds a batch of events,
+// each possibly from a different watched collection, into the single CompoundCursor token
+// that WatchMulti hands back to a caller following several resources through one connection.
+func GetEventCursorMulti(events []*CollectionEvent) (string, error) {
+	if len(events) == 0 {
+		return "", errors.New("no event to build a compound cursor from")
+	}
+
+	elements := make([]CompoundCursorElement, 0, len(events))
+	for _, e := range events {
+		curType, exist := GetCursorTypeByCollection(e.Collection)
+		if !exist {
+			blog.Errorf("unsupported cursor type collection: %s, oid: %s", e.Collection, e.Event.Oid)
+			return "", fmt.Errorf("unsupported cursor type collection: %s", e.Collection)
+		}
+
+		elements = append(elements, CompoundCursorElement{
+			Type:        curType,
+			ClusterTime: e.Event.ClusterTime,
+			Oid:         e.Event.Oid,
+		})
+	}
+
+	cursor := CompoundCursor{Elements: elements}
+	encoded, err := cursor.Encode()
+	if err != nil {
+		blog.Errorf("encode compound cursor failed, err: %v", err)
+		return "", err
+	}
+
+	return encoded, nil
+}
+
+// ResourceWatcher resumes a single CursorType's event stream from the given single-resource
+// cursor (a zero Cursor means start watching from the head). It's implemented by whichever
+// layer owns the mongodb change stream for that resource; WatchMulti only knows how to fan a
+// CompoundCursor out into per-resource cursors and fold the per-resource streams back into
+// one cluster-time-ordered stream. Implementations must honor ctx: once it's canceled they
+// need to stop watching and close the returned channel promptly, since that's the only way
+// mergeByClusterTime's per-sub-stream goroutines are able to unblock and exit.
+type ResourceWatcher interface {
+	Watch(ctx context.Context, typ CursorType, resumeFrom Cursor) (<-chan *types.Event, error)
+}
+
+// WatchMulti opens a merged, cluster-time-ordered event stream over several resources at
+// once, resuming each one from its own component of resumeFrom. This is what lets a caller
+// like the topology view follow hosts, host_relations and modules through a single
+// connection instead of opening one watch stream per resource and reconciling them itself.
+// Canceling ctx (e.g. when the caller's HTTP client disconnects) stops the merge goroutine
+// and is propagated to every per-resource ResourceWatcher.Watch call.
+func WatchMulti(ctx context.Context, watcher ResourceWatcher, cursorTypes []CursorType,
+	resumeFrom CompoundCursor) (<-chan *types.Event, error) {
+
+	if len(cursorTypes) == 0 {
+		return nil, errors.New("watch multi requires at least one cursor type")
+	}
+
+	resumePoints := make(map[CursorType]Cursor, len(resumeFrom.Elements))
+	for _, ele := range resumeFrom.Elements {
+		resumePoints[ele.Type] = Cursor{Type: ele.Type, ClusterTime: ele.ClusterTime, Oid: ele.Oid}
+	}
+
+	subs := make([]<-chan *types.Event, 0, len(cursorTypes))
+	for _, typ := range cursorTypes {
+		sub, err := watcher.Watch(ctx, typ, resumePoints[typ])
+		if err != nil {
+			return nil, fmt.Errorf("watch %s failed, err: %v", typ, err)
+		}
+		subs = append(subs, sub)
+	}
+
+	return mergeByClusterTime(ctx, subs), nil
+}
+
+// mergeByClusterTime folds several per-resource event streams into one, always emitting the
+// globally earliest ClusterTime next. Because the sub-streams are independent and async, it
+// has to wait for every still-open sub-stream to offer its next event before it can be sure
+// which one is earliest - so throughput is gated by the slowest open sub-stream, which is the
+// price of a strictly time-ordered fan-in: a resource that's been idle for hours (e.g. biz,
+// with no bizzes changing) will stall delivery of every other resource's events until it
+// either produces an event or ctx is canceled. Callers that can't tolerate that should bound
+// it with a ctx deadline, or have their ResourceWatcher emit a periodic no-op/heartbeat event
+// (the way NoEventCursor lets a single watcher signal "nothing happened yet") so refill never
+// blocks indefinitely on one resource.
+//
+// ctx also bounds this function's own goroutines: once it's canceled, every in-flight refill
+// unblocks and the merge goroutine returns, so neither leaks even if a caller stops reading
+// from the returned channel.
+func mergeByClusterTime(ctx context.Context, subs []<-chan *types.Event) <-chan *types.Event {
+	out := make(chan *types.Event)
+
+	go func() {
+		defer close(out)
+
+		heads := make([]*types.Event, len(subs))
+		closed := make([]bool, len(subs))
+
+		refill := func(indices []int) {
+			wg := sync.WaitGroup{}
+			wg.Add(len(indices))
+			for _, i := range indices {
+				i := i
+				go func() {
+					defer wg.Done()
+					select {
+					case e, ok := <-subs[i]:
+						if !ok {
+							closed[i] = true
+							heads[i] = nil
+							return
+						}
+						heads[i] = e
+					case <-ctx.Done():
+					}
+				}()
+			}
+			wg.Wait()
+		}
+
+		all := make([]int, len(subs))
+		for i := range subs {
+			all[i] = i
+		}
+		refill(all)
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			minIdx := -1
+			for i := range heads {
+				if closed[i] || heads[i] == nil {
+					continue
+				}
+				if minIdx == -1 || clusterTimeBefore(heads[i].ClusterTime, heads[minIdx].ClusterTime) {
+					minIdx = i
+				}
+			}
+
+			if minIdx == -1 {
+				// every sub-stream is closed.
+				return
+			}
+
+			select {
+			case out <- heads[minIdx]:
+			case <-ctx.Done():
+				return
+			}
+
+			refill([]int{minIdx})
+		}
+	}()
+
+	return out
+}
+
+func clusterTimeBefore(a, b types.TimeStamp) bool {
+	if a.Sec != b.Sec {
+		return a.Sec < b.Sec
+	}
+	return a.Nano < b.Nano
+}
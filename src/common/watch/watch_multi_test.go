@@ -0,0 +1,104 @@
+/*
+ * Tencent is pleased to support the open source community by making 蓝鲸 available.
+ * Copyright (C) 2017-2018 THL A29 Limited, a Tencent company. All rights reserved.
+ * Licensed under the MIT License (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ * http://opensource.org/licenses/MIT
+ * Unless required by applicable law or agreed to in writing, software distributed under
+ * the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+ * either express or implied. See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"configcenter/src/storage/stream/types"
+)
+
+// fakeResourceWatcher hands back a pre-made channel per CursorType, so a test can control
+// exactly when and in what order each resource's events arrive.
+type fakeResourceWatcher struct {
+	channels map[CursorType]chan *types.Event
+}
+
+func (f *fakeResourceWatcher) Watch(_ context.Context, typ CursorType, _ Cursor) (<-chan *types.Event, error) {
+	return f.channels[typ], nil
+}
+
+func TestWatchMultiOrdersEventsByClusterTime(t *testing.T) {
+	hostCh := make(chan *types.Event, 1)
+	bizCh := make(chan *types.Event, 1)
+	watcher := &fakeResourceWatcher{channels: map[CursorType]chan *types.Event{Host: hostCh, Biz: bizCh}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, err := WatchMulti(ctx, watcher, []CursorType{Host, Biz}, CompoundCursor{})
+	if err != nil {
+		t.Fatalf("watch multi failed: %v", err)
+	}
+
+	later := &types.Event{Oid: "later", ClusterTime: types.TimeStamp{Sec: 200}}
+	earlier := &types.Event{Oid: "earlier", ClusterTime: types.TimeStamp{Sec: 100}}
+
+	// send the later event to the first resource before the earlier event reaches the second
+	// one, so the test would fail if WatchMulti just forwarded events in arrival order.
+	hostCh <- later
+	bizCh <- earlier
+
+	first := recvWithTimeout(t, out)
+	if first.Oid != "earlier" {
+		t.Fatalf("expected the earlier cluster time event first, got %q", first.Oid)
+	}
+
+	// the merge now waits for biz's next event before it can hand out the held host event;
+	// closing biz tells it biz has nothing more to send.
+	close(bizCh)
+
+	second := recvWithTimeout(t, out)
+	if second.Oid != "later" {
+		t.Fatalf("expected the later cluster time event second, got %q", second.Oid)
+	}
+}
+
+func TestWatchMultiCancelClosesOutput(t *testing.T) {
+	hostCh := make(chan *types.Event)
+	watcher := &fakeResourceWatcher{channels: map[CursorType]chan *types.Event{Host: hostCh}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out, err := WatchMulti(ctx, watcher, []CursorType{Host}, CompoundCursor{})
+	if err != nil {
+		t.Fatalf("watch multi failed: %v", err)
+	}
+
+	// no one ever writes to hostCh, so the merge goroutine is parked waiting for it; canceling
+	// ctx must be what unblocks it and closes out, not a stray event.
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Fatalf("expected the output channel to be closed after ctx is canceled, got an event instead")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the output channel to close after ctx was canceled")
+	}
+}
+
+func recvWithTimeout(t *testing.T, ch <-chan *types.Event) *types.Event {
+	t.Helper()
+
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for an event from the merged stream")
+		return nil
+	}
+}